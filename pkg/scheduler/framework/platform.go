@@ -0,0 +1,49 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+// Platform identifies one entry of a multi-arch image manifest list / OCI image index, e.g.
+// {OS: "linux", Architecture: "arm64"}. It mirrors the subset of a node's reported
+// status.nodeInfo used to pick which manifest entry the node would actually pull.
+type Platform struct {
+	OS           string
+	Architecture string
+	Variant      string
+}
+
+// PlatformManifest is the per-platform manifest digest and layer set of one entry of a
+// manifest-list / OCI image-index image.
+type PlatformManifest struct {
+	// Digest is the manifest digest of this platform's entry within the index.
+	Digest string
+	// Layers maps a content layer digest to its size in bytes.
+	Layers map[string]int64
+}
+
+// ImageIndexResolver resolves a manifest-list / OCI image-index reference to the manifest
+// and layer set each of its platform entries contains. With it, locality accounting can scope
+// "does this node already have this image's layers" to the one platform entry a given node
+// would actually pull, rather than crediting a node for layers belonging to an architecture
+// it will never fetch.
+//
+// No caller in this package resolves an index through it yet; it's intentionally inert until
+// an ImageLocality-style consumer that needs pre-pull platform visibility lands.
+type ImageIndexResolver interface {
+	// ResolveIndex returns the per-platform manifests that make up ref, or a nil map if
+	// ref isn't a manifest list / image index (or couldn't be resolved).
+	ResolveIndex(ref string) (map[Platform]PlatformManifest, error)
+}
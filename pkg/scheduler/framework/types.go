@@ -0,0 +1,92 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// ImageStateSummary provides summarized information about the state of an image.
+type ImageStateSummary struct {
+	// Size of the image.
+	Size int64
+	// NumNodes is the number of nodes in the cluster known to have this image.
+	NumNodes int
+	// LayersOnNodes maps a content layer digest that makes up this image to the set of node
+	// names already known to have that layer, independent of whether they have this exact
+	// image. It lets locality scoring credit a node for layers it shares with an image even
+	// when it doesn't have that image's tag.
+	LayersOnNodes map[string]sets.String
+	// LayersSize maps a content layer digest that makes up this image to its size in bytes.
+	LayersSize map[string]int64
+	// LayersPlatform maps a content layer digest that makes up this image to the platform
+	// (os/arch/variant) it was pulled for. Manifest-list / OCI image-index images have
+	// disjoint layer sets per platform, so LayersOnNodes for one digest must only ever be
+	// compared against nodes of the same platform; this is the field that records which one.
+	// It's populated by getNodeImageStates but has no reader in this package yet — intentionally
+	// inert until a plugin-side consumer that scores per-platform locality lands.
+	LayersPlatform map[string]Platform
+}
+
+// NodeInfo is node level aggregated information.
+type NodeInfo struct {
+	// node is the underlying node object.
+	node *v1.Node
+
+	// ImageStates holds the locality information for every image reported as present in the
+	// cluster, keyed by image name, regardless of whether this particular node has it.
+	ImageStates map[string]*ImageStateSummary
+}
+
+// NewNodeInfo returns a ready to use empty NodeInfo object.
+func NewNodeInfo(node *v1.Node) *NodeInfo {
+	return &NodeInfo{node: node}
+}
+
+// Node returns the underlying node object of this NodeInfo.
+func (n *NodeInfo) Node() *v1.Node {
+	if n == nil {
+		return nil
+	}
+	return n.node
+}
+
+// MissingBytesFor returns the number of bytes of imageName that still need to be pulled onto
+// this node, computed as the sum of the sizes of the image's layers that aren't already known
+// to be present here. An image with no entry in ImageStates reports zero, and an image whose
+// layers are unknown costs its full Size whether or not this node has it.
+func (n *NodeInfo) MissingBytesFor(imageName string) int64 {
+	if n == nil || n.node == nil {
+		return 0
+	}
+	state, ok := n.ImageStates[imageName]
+	if !ok {
+		return 0
+	}
+	if len(state.LayersSize) == 0 {
+		return state.Size
+	}
+
+	var missing int64
+	for layer, size := range state.LayersSize {
+		if nodesWithLayer := state.LayersOnNodes[layer]; nodesWithLayer == nil || !nodesWithLayer.Has(n.node.Name) {
+			missing += size
+		}
+	}
+	return missing
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// digestSeparator is the separator the kubelet and container runtimes use between the
+// repository portion of an image reference and its content digest, e.g.
+// "gcr.io/app@sha256:abcd...".
+const digestSeparator = "@sha256:"
+
+// TagResolver resolves a possibly-tagged image reference to the content digest it currently
+// points at. Tags are mutable, so a reference like "gcr.io/app:v1" can't be trusted to mean
+// the same blob on every node; a resolver lets scheduling consult an authoritative source
+// (or at least a recent one) instead of treating every differing tag as a cache miss.
+type TagResolver interface {
+	// ResolveDigest returns the digest ref currently points at, and false if it couldn't
+	// be resolved (e.g. ref already carries one, or the resolver has no opinion).
+	ResolveDigest(ref string) (digest string, ok bool)
+}
+
+// noopTagResolver never resolves a tag. It preserves today's behavior, where a pod image is
+// only credited against nodes reporting the exact same string, for callers that haven't
+// wired in a real resolver yet.
+type noopTagResolver struct{}
+
+func (noopTagResolver) ResolveDigest(ref string) (string, bool) { return "", false }
+
+// DefaultTagResolver is the TagResolver for callers that don't configure their own.
+// resolveImageLocality does not fall back to it: a nil resolver there skips tag resolution.
+var DefaultTagResolver TagResolver = noopTagResolver{}
+
+// parseDigest returns the digest part of a digest-qualified image reference, e.g.
+// "gcr.io/app@sha256:abcd" -> ("sha256:abcd", true), and false if ref carries no digest.
+func parseDigest(ref string) (digest string, ok bool) {
+	idx := strings.Index(ref, digestSeparator)
+	if idx == -1 {
+		return "", false
+	}
+	return ref[idx+1:], true
+}
+
+// contentKey returns the strongest content identity image carries: the digest out of an
+// "@sha256:..." name if one of its Names has one, falling back to the digest parsed out of
+// the kubelet-reported ImageID (e.g. "docker-pullable://gcr.io/app@sha256:abcd...") when no
+// name is digest-qualified but the node still told us what it actually pulled. Both sources
+// are run through parseDigest so they land in the same "sha256:..." key space and fold
+// together when they name the same content; ImageID is used bare only on the rare runtime
+// that reports it without a parseable digest suffix, in which case it can only match another
+// node reporting the identical raw string. It returns false if no content identity is
+// available at all, meaning this image entry can only be matched by tag.
+func contentKey(image v1.ContainerImage) (key string, ok bool) {
+	for _, name := range image.Names {
+		if digest, ok := parseDigest(name); ok {
+			return digest, true
+		}
+	}
+	if digest, ok := parseDigest(image.ImageID); ok {
+		return digest, true
+	}
+	if image.ImageID != "" {
+		return image.ImageID, true
+	}
+	return "", false
+}
+
+// createDigestExistenceMap returns a map recording content identities to the set of nodes
+// that have reported an image resolving to them, keyed by whichever of contentKey's sources
+// an image entry carries. It complements createImageExistenceMap's tag-keyed view: the same
+// content can appear under several tags, and a tag can be reused for different content, so
+// neither map alone is a reliable answer to "does this node already have this image". Keying
+// on ImageID as well as digest-qualified names means two nodes reporting the same tag with
+// different ImageIDs land in different buckets, rather than being treated as the same image.
+func createDigestExistenceMap(nodes []*v1.Node) map[string]sets.String {
+	digestExistenceMap := make(map[string]sets.String)
+	for _, node := range nodes {
+		for _, image := range node.Status.Images {
+			key, ok := contentKey(image)
+			if !ok {
+				continue
+			}
+			if _, ok := digestExistenceMap[key]; !ok {
+				digestExistenceMap[key] = sets.NewString(node.Name)
+			} else {
+				digestExistenceMap[key].Insert(node.Name)
+			}
+		}
+	}
+	return digestExistenceMap
+}
+
+// resolveImageLocality returns the set of node names known to already have ref, consulting
+// both the tag-keyed imageExistenceMap and the digest-keyed digestExistenceMap so that a node
+// holding the same blob under a different tag is credited too. The result is the union of the
+// two: every node reporting ref by name is included, even one whose copy of a reused tag is
+// different content. If ref isn't already digest-qualified, resolver is
+// asked to resolve it before consulting the digest map; a nil resolver or one with no
+// opinion falls back to the tag map alone, matching today's behavior.
+func resolveImageLocality(ref string, imageExistenceMap, digestExistenceMap map[string]sets.String, resolver TagResolver) sets.String {
+	result := imageExistenceMap[ref].Union(nil)
+
+	digest, ok := parseDigest(ref)
+	if !ok && resolver != nil {
+		digest, ok = resolver.ResolveDigest(ref)
+	}
+	if ok {
+		result = result.Union(digestExistenceMap[digest])
+	}
+	return result
+}
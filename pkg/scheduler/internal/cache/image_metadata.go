@@ -0,0 +1,204 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// ImageMetadataSource supplies size and layer metadata for an image reference that no node
+// has yet reported, so a freshly joined node with an empty NodeStatus.Images doesn't get
+// scored as if the pod's images were free to pull. The default source is a no-op that
+// preserves today's behavior of scoring such an image as a zero-byte unknown; a
+// registry-backed source can be swapped in to return real numbers. pullSecrets are the
+// requesting pod's imagePullSecrets, passed through so a source that needs to authenticate
+// against a private registry can do so with the same credentials the kubelet would use.
+type ImageMetadataSource interface {
+	// Resolve returns the total size and per-layer sizes of ref, or an error if it could
+	// not be determined.
+	Resolve(ref string, pullSecrets []v1.LocalObjectReference) (size int64, layers map[string]int64, err error)
+}
+
+// noopImageMetadataSource never resolves anything, preserving today's behavior.
+type noopImageMetadataSource struct{}
+
+func (noopImageMetadataSource) Resolve(ref string, pullSecrets []v1.LocalObjectReference) (int64, map[string]int64, error) {
+	return 0, nil, nil
+}
+
+// DefaultImageMetadataSource is used when no ImageMetadataSource has been configured.
+var DefaultImageMetadataSource ImageMetadataSource = noopImageMetadataSource{}
+
+// imageMetadataCacheEntry is one bookkept ImageMetadataSource lookup.
+type imageMetadataCacheEntry struct {
+	ref      string
+	size     int64
+	layers   map[string]int64
+	negative bool
+}
+
+// imageMetadataCache is a bounded, size-evicting, in-memory cache of ImageMetadataSource
+// lookups, keyed by image reference. It is shared across scheduling cycles so that a
+// source isn't re-probed for every pod that mentions the same image, and so that a source
+// that's erroring (registry unreachable, image missing) doesn't get hammered every cycle a
+// cold node shows up.
+type imageMetadataCache struct {
+	mu       sync.Mutex
+	source   ImageMetadataSource
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+// newImageMetadataCache returns an imageMetadataCache backed by source, holding at most
+// capacity entries; a capacity of zero or less means entries are never evicted.
+func newImageMetadataCache(source ImageMetadataSource, capacity int) *imageMetadataCache {
+	return &imageMetadataCache{
+		source:   source,
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// resolve returns the size and layers of ref, consulting the cache first and the backing
+// source on a miss. ok is false if the source couldn't resolve ref (including a cached
+// negative result from an earlier failed lookup), in which case callers should fall back to
+// treating ref as an unknown, same as before this cache existed. pullSecrets are only used on
+// a miss, to authenticate the probe; the cache is keyed on ref alone, so the first pod to ask
+// about a given cold image determines which secrets are used to prime the cache for it.
+func (c *imageMetadataCache) resolve(ref string, pullSecrets []v1.LocalObjectReference) (size int64, layers map[string]int64, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, found := c.entries[ref]; found {
+		c.order.MoveToFront(elem)
+		entry := elem.Value.(*imageMetadataCacheEntry)
+		return entry.size, entry.layers, !entry.negative
+	}
+
+	entry := &imageMetadataCacheEntry{ref: ref}
+	size, layers, err := c.source.Resolve(ref, pullSecrets)
+	if err != nil {
+		entry.negative = true
+	} else {
+		entry.size, entry.layers = size, layers
+	}
+	c.insert(entry)
+
+	return entry.size, entry.layers, !entry.negative
+}
+
+// insert adds entry to the cache, evicting the least recently used entry if at capacity.
+// Callers must hold c.mu.
+func (c *imageMetadataCache) insert(entry *imageMetadataCacheEntry) {
+	c.entries[entry.ref] = c.order.PushFront(entry)
+	if c.capacity <= 0 || c.order.Len() <= c.capacity {
+		return
+	}
+	oldest := c.order.Back()
+	c.order.Remove(oldest)
+	delete(c.entries, oldest.Value.(*imageMetadataCacheEntry).ref)
+}
+
+// resolveUnknownImageState consults cache for a pod image absent from imageExistenceMap
+// (no node has reported it yet), returning an ImageStateSummary populated with best-effort
+// size and layer information instead of the zero value the ImageLocality plugin would
+// otherwise score as "free to pull". It returns nil, false if the source couldn't supply
+// anything, in which case callers should fall back to today's behavior.
+func resolveUnknownImageState(cache *imageMetadataCache, ref string, pullSecrets []v1.LocalObjectReference) (*framework.ImageStateSummary, bool) {
+	size, layers, ok := cache.resolve(ref, pullSecrets)
+	if !ok {
+		return nil, false
+	}
+
+	state := &framework.ImageStateSummary{Size: size}
+	if len(layers) > 0 {
+		state.LayersSize = layers
+		state.LayersOnNodes = make(map[string]sets.String, len(layers))
+		for layer := range layers {
+			// No node has reported this image, so by definition no node has reported
+			// having any of its layers either.
+			state.LayersOnNodes[layer] = sets.String{}
+		}
+	}
+	return state, true
+}
+
+// ImageStateFor returns the locality state the ImageLocality plugin should score ref against
+// for node, composing the per-node view from getNodeImageStates with two fallbacks for images
+// node itself didn't report: if some other node in the cluster is known to have ref (by tag or
+// by content identity, via resolveImageLocality), that node count is used so the image isn't
+// scored as if nobody has ever pulled it; otherwise, if metadataCache is non-nil, it is
+// consulted so a node with an empty NodeStatus.Images (freshly joined, kubelet hasn't reported
+// yet) and an image nobody in the cluster has pulled yet are both scored on real bytes instead
+// of as a free zero-byte unknown. pullSecrets is threaded through to the metadata cache so a
+// registry-backed source can authenticate the probe.
+func ImageStateFor(node *v1.Node, ref string, imageExistenceMap, digestExistenceMap map[string]sets.String, resolver TagResolver, layersExistenceMapByPlatform map[framework.Platform]map[string]sets.String, metadataCache *imageMetadataCache, pullSecrets []v1.LocalObjectReference) *framework.ImageStateSummary {
+	if state, ok := getNodeImageStates(node, imageExistenceMap, digestExistenceMap, resolver, layersExistenceMapByPlatform)[ref]; ok {
+		return state
+	}
+
+	if nodesWithImage := resolveImageLocality(ref, imageExistenceMap, digestExistenceMap, resolver); len(nodesWithImage) > 0 {
+		return &framework.ImageStateSummary{NumNodes: len(nodesWithImage)}
+	}
+
+	if metadataCache != nil {
+		if state, ok := resolveUnknownImageState(metadataCache, ref, pullSecrets); ok {
+			return state
+		}
+	}
+
+	return &framework.ImageStateSummary{}
+}
+
+// CredentialedManifestFetcher fetches an image's manifest (and, where the registry exposes
+// it, per-layer sizes). It never fetches layer blobs, only the manifest itself (a HEAD/GET
+// against the manifest endpoint), so probing a cold node's images never pulls the very bytes
+// locality scoring is trying to help avoid pulling twice. pullSecrets names the pod's
+// imagePullSecrets by name in its namespace; implementations are expected to resolve them to
+// actual credentials the same way the kubelet's image pull manager does, rather than
+// receiving resolved credentials directly, so this interface doesn't need to change as that
+// resolution logic evolves.
+type CredentialedManifestFetcher interface {
+	FetchManifest(ref string, pullSecrets []v1.LocalObjectReference) (size int64, layers map[string]int64, err error)
+}
+
+// registryImageMetadataSource is an ImageMetadataSource backed by a
+// CredentialedManifestFetcher.
+type registryImageMetadataSource struct {
+	fetcher CredentialedManifestFetcher
+}
+
+// NewRegistryImageMetadataSource returns an ImageMetadataSource that resolves image
+// metadata via fetcher's manifest-only registry calls.
+func NewRegistryImageMetadataSource(fetcher CredentialedManifestFetcher) ImageMetadataSource {
+	return registryImageMetadataSource{fetcher: fetcher}
+}
+
+func (s registryImageMetadataSource) Resolve(ref string, pullSecrets []v1.LocalObjectReference) (int64, map[string]int64, error) {
+	if s.fetcher == nil {
+		return 0, nil, fmt.Errorf("no manifest fetcher configured")
+	}
+	return s.fetcher.FetchManifest(ref, pullSecrets)
+}
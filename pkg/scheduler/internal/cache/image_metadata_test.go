@@ -0,0 +1,233 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// countingSource records how many times Resolve was called per ref, so tests can assert on
+// caching (and negative caching) behavior without a real registry.
+type countingSource struct {
+	calls       map[string]int
+	size        int64
+	layers      map[string]int64
+	failRef     map[string]bool
+	pullSecrets []v1.LocalObjectReference
+}
+
+func (s *countingSource) Resolve(ref string, pullSecrets []v1.LocalObjectReference) (int64, map[string]int64, error) {
+	if s.calls == nil {
+		s.calls = make(map[string]int)
+	}
+	s.calls[ref]++
+	s.pullSecrets = pullSecrets
+	if s.failRef[ref] {
+		return 0, nil, fmt.Errorf("registry unreachable for %s", ref)
+	}
+	return s.size, s.layers, nil
+}
+
+func TestImageMetadataCacheResolve(t *testing.T) {
+	source := &countingSource{size: int64(30 * mb), layers: map[string]int64{"layer1": int64(30 * mb)}}
+	cache := newImageMetadataCache(source, 10)
+
+	for i := 0; i < 3; i++ {
+		size, layers, ok := cache.resolve("gcr.io/cold:v1", nil)
+		if !ok || size != int64(30*mb) || !reflect.DeepEqual(layers, source.layers) {
+			t.Fatalf("unexpected resolve result: size=%d layers=%#v ok=%v", size, layers, ok)
+		}
+	}
+	if got := source.calls["gcr.io/cold:v1"]; got != 1 {
+		t.Errorf("expected the source to be probed once and then served from cache, got %d calls", got)
+	}
+}
+
+func TestImageMetadataCacheNegativeCachingAvoidsHotLoop(t *testing.T) {
+	source := &countingSource{failRef: map[string]bool{"gcr.io/missing:v1": true}}
+	cache := newImageMetadataCache(source, 10)
+
+	for i := 0; i < 5; i++ {
+		if _, _, ok := cache.resolve("gcr.io/missing:v1", nil); ok {
+			t.Fatalf("expected resolve to report failure for an unreachable registry")
+		}
+	}
+	if got := source.calls["gcr.io/missing:v1"]; got != 1 {
+		t.Errorf("expected a single registry probe followed by negative caching, got %d calls", got)
+	}
+}
+
+func TestImageMetadataCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	source := &countingSource{size: int64(mb)}
+	cache := newImageMetadataCache(source, 1)
+
+	cache.resolve("gcr.io/a:v1", nil)
+	cache.resolve("gcr.io/b:v1", nil)
+	cache.resolve("gcr.io/a:v1", nil)
+
+	if got := source.calls["gcr.io/a:v1"]; got != 2 {
+		t.Errorf("expected gcr.io/a:v1 to be evicted and re-probed once b:v1 filled the single slot, got %d calls", got)
+	}
+}
+
+func TestImageMetadataCacheThreadsPullSecretsOnMiss(t *testing.T) {
+	source := &countingSource{size: int64(mb)}
+	cache := newImageMetadataCache(source, 10)
+	pullSecrets := []v1.LocalObjectReference{{Name: "regcred"}}
+
+	cache.resolve("gcr.io/private:v1", pullSecrets)
+	if !reflect.DeepEqual(source.pullSecrets, pullSecrets) {
+		t.Errorf("expected pullSecrets %#v to reach the source, got %#v", pullSecrets, source.pullSecrets)
+	}
+}
+
+func TestResolveUnknownImageStateDegradesGracefullyOnRegistryFailure(t *testing.T) {
+	source := &countingSource{failRef: map[string]bool{"gcr.io/cold:v1": true}}
+	cache := newImageMetadataCache(source, 10)
+
+	state, ok := resolveUnknownImageState(cache, "gcr.io/cold:v1", nil)
+	if ok || state != nil {
+		t.Errorf("expected a nil state and ok=false when the source can't resolve the image, got state=%#v ok=%v", state, ok)
+	}
+}
+
+func TestResolveUnknownImageStatePopulatesLayerLocality(t *testing.T) {
+	source := &countingSource{
+		size:   int64(75 * mb),
+		layers: map[string]int64{"base-layer": int64(50 * mb), "app-layer": int64(25 * mb)},
+	}
+	cache := newImageMetadataCache(source, 10)
+
+	state, ok := resolveUnknownImageState(cache, "gcr.io/cold:v1", nil)
+	if !ok {
+		t.Fatalf("expected resolveUnknownImageState to succeed")
+	}
+	if state.Size != int64(75*mb) {
+		t.Errorf("expected Size %d, got %d", int64(75*mb), state.Size)
+	}
+	for layer, size := range source.layers {
+		if got := state.LayersSize[layer]; got != size {
+			t.Errorf("expected LayersSize[%q] = %d, got %d", layer, size, got)
+		}
+		if nodes := state.LayersOnNodes[layer]; nodes == nil || nodes.Len() != 0 {
+			t.Errorf("expected LayersOnNodes[%q] to be an empty set since no node has reported this image, got %#v", layer, nodes)
+		}
+	}
+}
+
+func TestRegistryImageMetadataSourceRequiresFetcher(t *testing.T) {
+	source := NewRegistryImageMetadataSource(nil)
+	if _, _, err := source.Resolve("gcr.io/cold:v1", nil); err == nil {
+		t.Errorf("expected an error when no CredentialedManifestFetcher is configured")
+	}
+}
+
+// stubFetcher is a CredentialedManifestFetcher that records the pullSecrets it was called
+// with, so tests can assert they flow from the pod through to the registry client.
+type stubFetcher struct {
+	size        int64
+	layers      map[string]int64
+	pullSecrets []v1.LocalObjectReference
+}
+
+func (f *stubFetcher) FetchManifest(ref string, pullSecrets []v1.LocalObjectReference) (int64, map[string]int64, error) {
+	f.pullSecrets = pullSecrets
+	return f.size, f.layers, nil
+}
+
+func TestRegistryImageMetadataSourceThreadsPullSecrets(t *testing.T) {
+	fetcher := &stubFetcher{size: int64(mb)}
+	source := NewRegistryImageMetadataSource(fetcher)
+	pullSecrets := []v1.LocalObjectReference{{Name: "regcred"}}
+
+	if _, _, err := source.Resolve("gcr.io/private:v1", pullSecrets); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(fetcher.pullSecrets, pullSecrets) {
+		t.Errorf("expected pullSecrets %#v to reach the fetcher, got %#v", pullSecrets, fetcher.pullSecrets)
+	}
+}
+
+func TestImageStateForUsesNodesOwnReportedState(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-0"},
+		Status: v1.NodeStatus{
+			Images: []v1.ContainerImage{
+				{
+					Names:     []string{"gcr.io/app:v1"},
+					SizeBytes: int64(50 * mb),
+					Layers:    map[string]int64{"layer1": int64(50 * mb)},
+				},
+			},
+		},
+	}
+	imageExistenceMap := map[string]sets.String{"gcr.io/app:v1": sets.NewString("node-0")}
+	layersExistenceMapByPlatform := map[framework.Platform]map[string]sets.String{{}: {"layer1": sets.NewString("node-0")}}
+
+	state := ImageStateFor(node, "gcr.io/app:v1", imageExistenceMap, map[string]sets.String{}, noopTagResolver{}, layersExistenceMapByPlatform, nil, nil)
+	if state.Size != int64(50*mb) {
+		t.Errorf("expected the node's own reported size %d, got %d", int64(50*mb), state.Size)
+	}
+}
+
+func TestImageStateForFallsBackToClusterWideKnowledge(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-0"},
+		Status:     v1.NodeStatus{},
+	}
+	imageExistenceMap := map[string]sets.String{"gcr.io/app:v1": sets.NewString("node-1", "node-2")}
+
+	state := ImageStateFor(node, "gcr.io/app:v1", imageExistenceMap, map[string]sets.String{}, noopTagResolver{}, nil, nil, nil)
+	if state.NumNodes != 2 {
+		t.Errorf("expected NumNodes 2 from cluster-wide knowledge despite node-0 not reporting it, got %d", state.NumNodes)
+	}
+}
+
+func TestImageStateForFallsBackToMetadataCacheWhenUnknownClusterWide(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-0"},
+		Status:     v1.NodeStatus{},
+	}
+	imageExistenceMap := map[string]sets.String{"gcr.io/app:v1": sets.NewString()}
+	source := &countingSource{size: int64(200 * mb), layers: map[string]int64{"layer1": int64(200 * mb)}}
+	cache := newImageMetadataCache(source, 10)
+
+	state := ImageStateFor(node, "gcr.io/app:v1", imageExistenceMap, map[string]sets.String{}, noopTagResolver{}, nil, cache, nil)
+	if state.Size != int64(200*mb) {
+		t.Errorf("expected the cold-node fallback size %d, got %d", int64(200*mb), state.Size)
+	}
+}
+
+func TestImageStateForDegradesGracefullyWithNoMetadataCache(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-0"},
+		Status:     v1.NodeStatus{},
+	}
+	imageExistenceMap := map[string]sets.String{"gcr.io/app:v1": sets.NewString()}
+
+	state := ImageStateFor(node, "gcr.io/app:v1", imageExistenceMap, map[string]sets.String{}, noopTagResolver{}, nil, nil, nil)
+	if state.Size != 0 || state.NumNodes != 0 {
+		t.Errorf("expected a zero-value state when nothing can resolve the image, got %#v", state)
+	}
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// nodePlatform returns the Platform a node would pull a manifest-list image's layers for,
+// derived from the architecture/operating system the kubelet reports in node status.
+func nodePlatform(node *v1.Node) framework.Platform {
+	return framework.Platform{
+		OS:           node.Status.NodeInfo.OperatingSystem,
+		Architecture: node.Status.NodeInfo.Architecture,
+	}
+}
+
+// createLayersExistenceMapByPlatform returns, for each reporting node's platform, a map of the
+// layer digests nodes of that platform have reported to the set of those nodes. A node only ever pulls the manifest entry matching its own architecture,
+// so a layer digest a node reports having is only ever relevant to other nodes of the same
+// platform; bucketing keeps an amd64 node's layers from being offered as locality credit to
+// an arm64 node that happens to share an image name with it.
+func createLayersExistenceMapByPlatform(nodes []*v1.Node) map[framework.Platform]map[string]sets.String {
+	result := make(map[framework.Platform]map[string]sets.String)
+	for _, node := range nodes {
+		platform := nodePlatform(node)
+		bucket, ok := result[platform]
+		if !ok {
+			bucket = make(map[string]sets.String)
+			result[platform] = bucket
+		}
+		for _, image := range node.Status.Images {
+			for layer := range image.Layers {
+				if _, ok := bucket[layer]; !ok {
+					bucket[layer] = sets.NewString(node.Name)
+				} else {
+					bucket[layer].Insert(node.Name)
+				}
+			}
+		}
+	}
+	return result
+}
+
+// nodeReportedIndexResolver is the default framework.ImageIndexResolver. The kubelet only
+// ever reports the single-platform manifest it actually pulled, so it has no visibility
+// into a multi-arch index's other platform entries and always reports "not an index I can
+// see into". A registry-backed implementation that reads the image index itself can replace
+// it once one exists.
+type nodeReportedIndexResolver struct{}
+
+func (nodeReportedIndexResolver) ResolveIndex(ref string) (map[framework.Platform]framework.PlatformManifest, error) {
+	return nil, nil
+}
+
+// DefaultImageIndexResolver is used when no framework.ImageIndexResolver has been configured.
+var DefaultImageIndexResolver framework.ImageIndexResolver = nodeReportedIndexResolver{}
@@ -0,0 +1,162 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// createImageExistenceMap returns a map recording image names to the set of nodes that
+// have reported having them, so locality scoring can tell which nodes already hold a
+// pod's images by exact name match.
+func createImageExistenceMap(nodes []*v1.Node) map[string]sets.String {
+	imageExistenceMap := make(map[string]sets.String)
+	for _, node := range nodes {
+		for _, image := range node.Status.Images {
+			for _, name := range image.Names {
+				if _, ok := imageExistenceMap[name]; !ok {
+					imageExistenceMap[name] = sets.NewString(node.Name)
+				} else {
+					imageExistenceMap[name].Insert(node.Name)
+				}
+			}
+		}
+	}
+	return imageExistenceMap
+}
+
+// createLayersOfImageMap returns a map recording image names to the set of content layer
+// digests that make them up, as reported by any node in the cluster. It seeds
+// imageLayerCache's layersOfImage below.
+func createLayersOfImageMap(nodes []*v1.Node) map[string]sets.String {
+	layersOfImage := make(map[string]sets.String)
+	for _, node := range nodes {
+		for _, image := range node.Status.Images {
+			for _, name := range image.Names {
+				if _, ok := layersOfImage[name]; !ok {
+					layersOfImage[name] = sets.String{}
+				}
+				for layer := range image.Layers {
+					layersOfImage[name].Insert(layer)
+				}
+			}
+		}
+	}
+	return layersOfImage
+}
+
+// getNodeImageStates returns the state information of all images on a node, populating each
+// image's locality bookkeeping (node count and, for images with known layers, which of their
+// layers this node's peers already have and how large each layer is) from the image and layer
+// existence maps built across the whole cluster. NumNodes is computed via resolveImageLocality
+// rather than a plain imageExistenceMap lookup, so a node is credited for an image reachable
+// under a different tag for the same content (digestExistenceMap, resolver) and not just an
+// exact name match. layersExistenceMapByPlatform is bucketed by platform (see
+// createLayersExistenceMapByPlatform) and scoped here to node's own platform, so a layer this
+// node reports is only ever compared against peers that would pull the same manifest entry.
+func getNodeImageStates(node *v1.Node, imageExistenceMap, digestExistenceMap map[string]sets.String, resolver TagResolver, layersExistenceMapByPlatform map[framework.Platform]map[string]sets.String) map[string]*framework.ImageStateSummary {
+	imageStates := make(map[string]*framework.ImageStateSummary)
+	platform := nodePlatform(node)
+	layersExistenceMap := layersExistenceMapByPlatform[platform]
+
+	for _, image := range node.Status.Images {
+		for _, name := range image.Names {
+			state := &framework.ImageStateSummary{
+				Size:     image.SizeBytes,
+				NumNodes: len(resolveImageLocality(name, imageExistenceMap, digestExistenceMap, resolver)),
+			}
+			if len(image.Layers) > 0 {
+				state.LayersOnNodes = make(map[string]sets.String, len(image.Layers))
+				state.LayersSize = make(map[string]int64, len(image.Layers))
+				state.LayersPlatform = make(map[string]framework.Platform, len(image.Layers))
+				for layer, size := range image.Layers {
+					state.LayersOnNodes[layer] = layersExistenceMap[layer]
+					state.LayersSize[layer] = size
+					state.LayersPlatform[layer] = platform
+				}
+			}
+			imageStates[name] = state
+		}
+	}
+	return imageStates
+}
+
+// imageLayerCache indexes the layer digests that make up each image reported across the
+// cluster and the nodes already known to have each digest, so the ImageLocality plugin can
+// score nodes on bytes still needing to be pulled instead of on exact-tag presence alone.
+// It is rebuilt incrementally as nodes report updated image lists. Layer presence is bucketed
+// by platform, mirroring createLayersExistenceMapByPlatform, so an amd64 node's layers are
+// never offered as locality credit to an arm64 node that happens to share an image name.
+type imageLayerCache struct {
+	// layersOfImage maps an image name to every layer digest any node has reported for it.
+	// updateNode only adds to it and removeNode leaves it as it is, so layers an image name
+	// no longer has are not dropped.
+	layersOfImage map[string]sets.String
+	// layersOnNodes maps a platform to the layer digests known for it and the set of node
+	// names, of that same platform, that have reported already having each one.
+	layersOnNodes map[framework.Platform]map[string]sets.String
+}
+
+// newImageLayerCache builds an imageLayerCache from the current node list. It can then be
+// patched with updateNode and removeNode as nodes are added, removed, or report a changed
+// image list.
+func newImageLayerCache(nodes []*v1.Node) *imageLayerCache {
+	return &imageLayerCache{
+		layersOfImage: createLayersOfImageMap(nodes),
+		layersOnNodes: createLayersExistenceMapByPlatform(nodes),
+	}
+}
+
+// updateNode incorporates a single node's currently reported images into the cache. It first
+// drops the node from every layer's node set, so layer-to-node associations for images the
+// node no longer reports don't linger.
+func (c *imageLayerCache) updateNode(node *v1.Node) {
+	c.removeNode(node.Name)
+	platform := nodePlatform(node)
+	bucket, ok := c.layersOnNodes[platform]
+	if !ok {
+		bucket = make(map[string]sets.String)
+		c.layersOnNodes[platform] = bucket
+	}
+	for _, image := range node.Status.Images {
+		for _, name := range image.Names {
+			if _, ok := c.layersOfImage[name]; !ok {
+				c.layersOfImage[name] = sets.String{}
+			}
+			for layer := range image.Layers {
+				c.layersOfImage[name].Insert(layer)
+				if _, ok := bucket[layer]; !ok {
+					bucket[layer] = sets.NewString(node.Name)
+				} else {
+					bucket[layer].Insert(node.Name)
+				}
+			}
+		}
+	}
+}
+
+// removeNode drops nodeName from every platform bucket's layer node sets, e.g. when the node
+// is deleted or is about to report a fresh image list in updateNode.
+func (c *imageLayerCache) removeNode(nodeName string) {
+	for _, bucket := range c.layersOnNodes {
+		for _, nodes := range bucket {
+			nodes.Delete(nodeName)
+		}
+	}
+}
@@ -80,6 +80,9 @@ func TestGetNodeImageStates(t *testing.T) {
 					LayersSize: map[string]int64{
 						"layer1": int64(10 * mb),
 					},
+					LayersPlatform: map[string]framework.Platform{
+						"layer1": {},
+					},
 				},
 				"gcr.io/200:v1": {
 					Size:     int64(200 * mb),
@@ -90,6 +93,9 @@ func TestGetNodeImageStates(t *testing.T) {
 					LayersSize: map[string]int64{
 						"layer2": int64(200 * mb),
 					},
+					LayersPlatform: map[string]framework.Platform{
+						"layer2": {},
+					},
 				},
 			},
 		},
@@ -112,7 +118,8 @@ func TestGetNodeImageStates(t *testing.T) {
 
 	for i, test := range tests {
 		t.Run(fmt.Sprintf("case_%d", i), func(t *testing.T) {
-			imageStates := getNodeImageStates(test.node, test.imageExistenceMap, test.layersExistenceMap)
+			layersExistenceMapByPlatform := map[framework.Platform]map[string]sets.String{{}: test.layersExistenceMap}
+			imageStates := getNodeImageStates(test.node, test.imageExistenceMap, map[string]sets.String{}, noopTagResolver{}, layersExistenceMapByPlatform)
 			if !reflect.DeepEqual(test.expected, imageStates) {
 				t.Errorf("expected: %#v, got: %#v", test.expected, imageStates)
 			}
@@ -120,6 +127,252 @@ func TestGetNodeImageStates(t *testing.T) {
 	}
 }
 
+func TestGetNodeImageStatesPartialLayerHit(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-0"},
+		Status: v1.NodeStatus{
+			Images: []v1.ContainerImage{
+				{
+					Names:     []string{"gcr.io/app:v2"},
+					SizeBytes: int64(150 * mb),
+					Layers: map[string]int64{
+						"base-layer": int64(100 * mb),
+						"app-layer":  int64(50 * mb),
+					},
+				},
+			},
+		},
+	}
+	imageExistenceMap := map[string]sets.String{
+		"gcr.io/app:v2": sets.NewString("node-0"),
+	}
+	layersExistenceMap := map[string]sets.String{
+		// base-layer was pulled by an unrelated image tag on node-1 and node-2; only
+		// app-layer is unique to this image and hasn't landed anywhere else yet.
+		"base-layer": sets.NewString("node-0", "node-1", "node-2"),
+		"app-layer":  sets.NewString("node-0"),
+	}
+
+	layersExistenceMapByPlatform := map[framework.Platform]map[string]sets.String{{}: layersExistenceMap}
+	imageStates := getNodeImageStates(node, imageExistenceMap, map[string]sets.String{}, noopTagResolver{}, layersExistenceMapByPlatform)
+
+	expected := map[string]*framework.ImageStateSummary{
+		"gcr.io/app:v2": {
+			Size:     int64(150 * mb),
+			NumNodes: 1,
+			LayersOnNodes: map[string]sets.String{
+				"base-layer": sets.NewString("node-0", "node-1", "node-2"),
+				"app-layer":  sets.NewString("node-0"),
+			},
+			LayersSize: map[string]int64{
+				"base-layer": int64(100 * mb),
+				"app-layer":  int64(50 * mb),
+			},
+			LayersPlatform: map[string]framework.Platform{
+				"base-layer": {},
+				"app-layer":  {},
+			},
+		},
+	}
+	if !reflect.DeepEqual(expected, imageStates) {
+		t.Errorf("expected: %#v, got: %#v", expected, imageStates)
+	}
+}
+
+func TestNodeInfoMissingBytesFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		nodeName string
+		states   map[string]*framework.ImageStateSummary
+		image    string
+		expected int64
+	}{
+		{
+			name:     "node has every layer",
+			nodeName: "node-1",
+			states: map[string]*framework.ImageStateSummary{
+				"gcr.io/10:v1": {
+					Size: int64(10 * mb),
+					LayersOnNodes: map[string]sets.String{
+						"layer1": sets.NewString("node-1"),
+					},
+					LayersSize: map[string]int64{"layer1": int64(10 * mb)},
+				},
+			},
+			image:    "gcr.io/10:v1",
+			expected: 0,
+		},
+		{
+			name:     "node is missing one of two layers",
+			nodeName: "node-1",
+			states: map[string]*framework.ImageStateSummary{
+				"gcr.io/app:v2": {
+					Size: int64(150 * mb),
+					LayersOnNodes: map[string]sets.String{
+						"base-layer": sets.NewString("node-1"),
+						"app-layer":  sets.NewString(),
+					},
+					LayersSize: map[string]int64{
+						"base-layer": int64(100 * mb),
+						"app-layer":  int64(50 * mb),
+					},
+				},
+			},
+			image:    "gcr.io/app:v2",
+			expected: int64(50 * mb),
+		},
+		{
+			name:     "image has no known layers falls back to full size",
+			nodeName: "node-1",
+			states: map[string]*framework.ImageStateSummary{
+				"gcr.io/200:v1": {Size: int64(200 * mb)},
+			},
+			image:    "gcr.io/200:v1",
+			expected: int64(200 * mb),
+		},
+		{
+			name:     "image unknown to this node costs nothing to report",
+			nodeName: "node-1",
+			states:   map[string]*framework.ImageStateSummary{},
+			image:    "gcr.io/unknown:v1",
+			expected: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := framework.NewNodeInfo(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: test.nodeName}})
+			node.ImageStates = test.states
+			if got := node.MissingBytesFor(test.image); got != test.expected {
+				t.Errorf("expected: %d, got: %d", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestImageLayerCacheUpdateNodeAndRemoveNode(t *testing.T) {
+	node0 := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-0"},
+		Status: v1.NodeStatus{
+			Images: []v1.ContainerImage{
+				{
+					Names:     []string{"gcr.io/app:v1"},
+					SizeBytes: int64(50 * mb),
+					Layers: map[string]int64{
+						"base-layer": int64(40 * mb),
+						"app-layer":  int64(10 * mb),
+					},
+				},
+			},
+		},
+	}
+	node1 := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: v1.NodeStatus{
+			Images: []v1.ContainerImage{
+				{
+					Names:     []string{"gcr.io/other:v1"},
+					SizeBytes: int64(40 * mb),
+					Layers: map[string]int64{
+						"base-layer": int64(40 * mb),
+					},
+				},
+			},
+		},
+	}
+
+	// Neither node reports a platform, so both land in the zero-value Platform bucket.
+	platform := framework.Platform{}
+
+	cache := newImageLayerCache([]*v1.Node{node0})
+	if !cache.layersOnNodes[platform]["base-layer"].Has("node-0") {
+		t.Fatalf("expected base-layer to be credited to node-0 after construction, got: %#v", cache.layersOnNodes)
+	}
+
+	// A second node reporting a shared layer should be folded in without disturbing node-0.
+	cache.updateNode(node1)
+	if !cache.layersOnNodes[platform]["base-layer"].Equal(sets.NewString("node-0", "node-1")) {
+		t.Errorf("expected base-layer to be credited to both nodes, got: %#v", cache.layersOnNodes[platform]["base-layer"])
+	}
+	if !cache.layersOfImage["gcr.io/other:v1"].Equal(sets.NewString("base-layer")) {
+		t.Errorf("expected gcr.io/other:v1 to be recorded as made up of base-layer, got: %#v", cache.layersOfImage["gcr.io/other:v1"])
+	}
+
+	// Re-reporting node-0 with a trimmed image list (app-layer evicted locally) must drop the
+	// stale association instead of leaving it behind.
+	node0Trimmed := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-0"},
+		Status: v1.NodeStatus{
+			Images: []v1.ContainerImage{
+				{
+					Names:     []string{"gcr.io/app:v1"},
+					SizeBytes: int64(50 * mb),
+					Layers: map[string]int64{
+						"base-layer": int64(40 * mb),
+					},
+				},
+			},
+		},
+	}
+	cache.updateNode(node0Trimmed)
+	if cache.layersOnNodes[platform]["app-layer"].Has("node-0") {
+		t.Errorf("expected node-0 to be dropped from app-layer after re-reporting without it, got: %#v", cache.layersOnNodes[platform]["app-layer"])
+	}
+	if !cache.layersOnNodes[platform]["base-layer"].Equal(sets.NewString("node-0", "node-1")) {
+		t.Errorf("expected base-layer associations to survive node-0's update, got: %#v", cache.layersOnNodes[platform]["base-layer"])
+	}
+
+	// Removing node-1 entirely must drop it from every layer's node set.
+	cache.removeNode("node-1")
+	if cache.layersOnNodes[platform]["base-layer"].Has("node-1") {
+		t.Errorf("expected node-1 to be dropped from base-layer after removeNode, got: %#v", cache.layersOnNodes[platform]["base-layer"])
+	}
+	if !cache.layersOnNodes[platform]["base-layer"].Has("node-0") {
+		t.Errorf("expected node-0 to remain on base-layer after removing node-1, got: %#v", cache.layersOnNodes[platform]["base-layer"])
+	}
+}
+
+func TestImageLayerCacheUpdateNodeKeepsPlatformsSeparate(t *testing.T) {
+	amdNode := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "amd-node-0"},
+		Status: v1.NodeStatus{
+			NodeInfo: v1.NodeSystemInfo{OperatingSystem: "linux", Architecture: "amd64"},
+			Images: []v1.ContainerImage{
+				{
+					Names:     []string{"gcr.io/app:v1"},
+					SizeBytes: int64(50 * mb),
+					Layers:    map[string]int64{"shared-digest": int64(50 * mb)},
+				},
+			},
+		},
+	}
+	armNode := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "arm-node-0"},
+		Status: v1.NodeStatus{
+			NodeInfo: v1.NodeSystemInfo{OperatingSystem: "linux", Architecture: "arm64"},
+			Images: []v1.ContainerImage{
+				{
+					Names:     []string{"gcr.io/app:v1"},
+					SizeBytes: int64(40 * mb),
+					Layers:    map[string]int64{"shared-digest": int64(40 * mb)},
+				},
+			},
+		},
+	}
+
+	cache := newImageLayerCache([]*v1.Node{amdNode})
+	cache.updateNode(armNode)
+
+	amdPlatform := framework.Platform{OS: "linux", Architecture: "amd64"}
+	armPlatform := framework.Platform{OS: "linux", Architecture: "arm64"}
+	if cache.layersOnNodes[amdPlatform]["shared-digest"].Has("arm-node-0") {
+		t.Errorf("expected arm-node-0 not to be credited in the amd64 bucket, got: %#v", cache.layersOnNodes[amdPlatform]["shared-digest"])
+	}
+	if cache.layersOnNodes[armPlatform]["shared-digest"].Has("amd-node-0") {
+		t.Errorf("expected amd-node-0 not to be credited in the arm64 bucket, got: %#v", cache.layersOnNodes[armPlatform]["shared-digest"])
+	}
+}
+
 func TestCreateImageExistenceMap(t *testing.T) {
 	tests := []struct {
 		nodes    []*v1.Node
@@ -207,3 +460,239 @@ func TestCreateImageExistenceMap(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateDigestExistenceMap(t *testing.T) {
+	nodes := []*v1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-0"},
+			Status: v1.NodeStatus{
+				Images: []v1.ContainerImage{
+					{
+						Names:     []string{"gcr.io/app:v1", "gcr.io/app@sha256:aaa"},
+						SizeBytes: int64(10 * mb),
+					},
+				},
+			},
+		},
+		{
+			// Same digest, different tag: should land in the same digest bucket as node-0.
+			ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+			Status: v1.NodeStatus{
+				Images: []v1.ContainerImage{
+					{
+						Names:     []string{"gcr.io/app:v2", "gcr.io/app@sha256:aaa"},
+						SizeBytes: int64(10 * mb),
+					},
+				},
+			},
+		},
+		{
+			// Tag reused for a different digest: must not be folded into the sha256:aaa bucket.
+			ObjectMeta: metav1.ObjectMeta{Name: "node-2"},
+			Status: v1.NodeStatus{
+				Images: []v1.ContainerImage{
+					{
+						Names:     []string{"gcr.io/app:v1", "gcr.io/app@sha256:bbb"},
+						SizeBytes: int64(10 * mb),
+					},
+				},
+			},
+		},
+	}
+
+	expected := map[string]sets.String{
+		"sha256:aaa": sets.NewString("node-0", "node-1"),
+		"sha256:bbb": sets.NewString("node-2"),
+	}
+	if got := createDigestExistenceMap(nodes); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected: %#v, got: %#v", expected, got)
+	}
+}
+
+func TestCreateDigestExistenceMapFallsBackToImageID(t *testing.T) {
+	nodes := []*v1.Node{
+		{
+			// No digest-qualified name, but the kubelet told us the ImageID it actually
+			// pulled: should be keyed by the digest parsed out of it.
+			ObjectMeta: metav1.ObjectMeta{Name: "node-0"},
+			Status: v1.NodeStatus{
+				Images: []v1.ContainerImage{
+					{
+						Names:     []string{"gcr.io/app:v1"},
+						ImageID:   "docker-pullable://gcr.io/app@sha256:aaa",
+						SizeBytes: int64(10 * mb),
+					},
+				},
+			},
+		},
+		{
+			// Same tag, but the kubelet resolved it to a different ImageID: the tag was
+			// reused for new content and must not be folded into node-0's bucket.
+			ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+			Status: v1.NodeStatus{
+				Images: []v1.ContainerImage{
+					{
+						Names:     []string{"gcr.io/app:v1"},
+						ImageID:   "docker-pullable://gcr.io/app@sha256:bbb",
+						SizeBytes: int64(10 * mb),
+					},
+				},
+			},
+		},
+		{
+			// Same ImageID as node-0, reached through a different tag: should land in
+			// node-0's bucket even though no name carries an explicit digest.
+			ObjectMeta: metav1.ObjectMeta{Name: "node-2"},
+			Status: v1.NodeStatus{
+				Images: []v1.ContainerImage{
+					{
+						Names:     []string{"gcr.io/app:stable"},
+						ImageID:   "docker-pullable://gcr.io/app@sha256:aaa",
+						SizeBytes: int64(10 * mb),
+					},
+				},
+			},
+		},
+		{
+			// Reports the same content as node-0 and node-2, but via a digest-qualified
+			// Name rather than ImageID: must fold into the same bucket as node-0 and
+			// node-2, since contentKey normalizes both sources through parseDigest.
+			ObjectMeta: metav1.ObjectMeta{Name: "node-3"},
+			Status: v1.NodeStatus{
+				Images: []v1.ContainerImage{
+					{
+						Names:     []string{"gcr.io/app@sha256:aaa"},
+						SizeBytes: int64(10 * mb),
+					},
+				},
+			},
+		},
+	}
+
+	expected := map[string]sets.String{
+		"sha256:aaa": sets.NewString("node-0", "node-2", "node-3"),
+		"sha256:bbb": sets.NewString("node-1"),
+	}
+	if got := createDigestExistenceMap(nodes); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected: %#v, got: %#v", expected, got)
+	}
+}
+
+func TestResolveImageLocality(t *testing.T) {
+	imageExistenceMap := map[string]sets.String{
+		"gcr.io/app:v1": sets.NewString("node-2"),
+	}
+	digestExistenceMap := map[string]sets.String{
+		"sha256:aaa": sets.NewString("node-0", "node-1"),
+	}
+
+	tests := []struct {
+		name     string
+		ref      string
+		resolver TagResolver
+		expected sets.String
+	}{
+		{
+			name:     "digest-qualified reference uses the digest map",
+			ref:      "gcr.io/app@sha256:aaa",
+			resolver: noopTagResolver{},
+			expected: sets.NewString("node-0", "node-1"),
+		},
+		{
+			name:     "tag reused for a new digest is not credited for the stale digest's nodes",
+			ref:      "gcr.io/app:v1",
+			resolver: noopTagResolver{},
+			expected: sets.NewString("node-2"),
+		},
+		{
+			name: "resolver maps the tag to its current digest",
+			ref:  "gcr.io/app:v1",
+			resolver: stubTagResolver{
+				digest: "sha256:aaa",
+				ok:     true,
+			},
+			expected: sets.NewString("node-0", "node-1", "node-2"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := resolveImageLocality(test.ref, imageExistenceMap, digestExistenceMap, test.resolver)
+			if !reflect.DeepEqual(test.expected, got) {
+				t.Errorf("expected: %#v, got: %#v", test.expected, got)
+			}
+		})
+	}
+}
+
+type stubTagResolver struct {
+	digest string
+	ok     bool
+}
+
+func (s stubTagResolver) ResolveDigest(ref string) (string, bool) { return s.digest, s.ok }
+
+func TestGetNodeImageStatesMixedArchCluster(t *testing.T) {
+	amdNode0 := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "amd-node-0"},
+		Status: v1.NodeStatus{
+			NodeInfo: v1.NodeSystemInfo{OperatingSystem: "linux", Architecture: "amd64"},
+			Images: []v1.ContainerImage{
+				{
+					Names:     []string{"gcr.io/app:v1"},
+					SizeBytes: int64(50 * mb),
+					Layers:    map[string]int64{"amd-layer": int64(50 * mb)},
+				},
+			},
+		},
+	}
+	amdNode1 := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "amd-node-1"},
+		Status: v1.NodeStatus{
+			NodeInfo: v1.NodeSystemInfo{OperatingSystem: "linux", Architecture: "amd64"},
+		},
+	}
+	armNode0 := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "arm-node-0"},
+		Status: v1.NodeStatus{
+			NodeInfo: v1.NodeSystemInfo{OperatingSystem: "linux", Architecture: "arm64"},
+			Images: []v1.ContainerImage{
+				{
+					Names:     []string{"gcr.io/app:v1"},
+					SizeBytes: int64(40 * mb),
+					Layers:    map[string]int64{"arm-layer": int64(40 * mb)},
+				},
+			},
+		},
+	}
+	nodes := []*v1.Node{amdNode0, amdNode1, armNode0}
+
+	imageExistenceMap := createImageExistenceMap(nodes)
+	layersExistenceMapByPlatform := createLayersExistenceMapByPlatform(nodes)
+
+	armStates := getNodeImageStates(armNode0, imageExistenceMap, map[string]sets.String{}, noopTagResolver{}, layersExistenceMapByPlatform)
+	armState := armStates["gcr.io/app:v1"]
+	armLayers := armState.LayersOnNodes
+	if _, ok := armLayers["amd-layer"]; ok {
+		t.Errorf("arm64 node's image state must not carry the amd64-only layer, got: %#v", armLayers)
+	}
+	if !armLayers["arm-layer"].Has("arm-node-0") {
+		t.Errorf("expected arm-node-0 to be credited for its own arm-layer, got: %#v", armLayers)
+	}
+	if got := armState.LayersPlatform["arm-layer"]; got != (framework.Platform{OS: "linux", Architecture: "arm64"}) {
+		t.Errorf("expected arm-layer to be tagged with the arm64 platform, got: %#v", got)
+	}
+
+	amdStates := getNodeImageStates(amdNode0, imageExistenceMap, map[string]sets.String{}, noopTagResolver{}, layersExistenceMapByPlatform)
+	amdState := amdStates["gcr.io/app:v1"]
+	amdLayers := amdState.LayersOnNodes
+	if _, ok := amdLayers["arm-layer"]; ok {
+		t.Errorf("amd64 node's image state must not carry the arm64-only layer, got: %#v", amdLayers)
+	}
+	if amdLayers["amd-layer"].Has("arm-node-0") {
+		t.Errorf("arm-node-0 must not be credited with the amd64-only layer, got: %#v", amdLayers)
+	}
+	if got := amdState.LayersPlatform["amd-layer"]; got != (framework.Platform{OS: "linux", Architecture: "amd64"}) {
+		t.Errorf("expected amd-layer to be tagged with the amd64 platform, got: %#v", got)
+	}
+}